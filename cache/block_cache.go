@@ -0,0 +1,308 @@
+// Package cache implements a client-side block cache for chunk reads,
+// modeled on the contiguous block cache used in readnetfs: fixed-size
+// blocks, one LRU shared by every open file, and a global byte budget so a
+// few hot files can't starve everything else out of the cache.
+package cache
+
+import (
+  "container/list"
+  "sync"
+)
+
+const (
+  // BlockSize is the unit the cache fetches and evicts in.
+  BlockSize = 1 << 20 // 1 MiB
+
+  // DefaultBudget is the total number of bytes the cache will hold across
+  // every open file, absent an override.
+  DefaultBudget = 1 << 30 // 1 GiB
+)
+
+// Fetcher retrieves one block's worth of bytes for a file, given the
+// block-aligned byte offset it starts at. Implementations typically call
+// FindLocations followed by a chunk server read RPC.
+type Fetcher interface {
+  FetchBlock(off int64) ([]byte, error)
+}
+
+// blockKey identifies one cached block: which file it belongs to and the
+// block-aligned offset within that file.
+//
+// Known limitation: file is keyed by the *CachedFile of the handle that
+// first cached the block, not by the underlying GFS path or chunk handle.
+// Two handles opened against the same path get independent blockKey
+// namespaces, so a write through one handle only invalidates that handle's
+// own cached blocks (via InvalidateRange) and never the other handle's
+// copies of the same bytes; a reader on the second handle can keep serving
+// stale data until its own length check (refreshLength) happens to fire or
+// it closes and reopens. Fixing this properly means keying blocks by path
+// or chunk handle and reference-counting across concurrently open
+// handles so Close() on one doesn't evict blocks another handle still
+// needs; left as follow-up work.
+type blockKey struct {
+  file *CachedFile
+  offset int64
+}
+
+// block is one cached block. mu is held across the fetch so concurrent
+// readers of the same block coalesce into a single network fetch instead
+// of each racing to populate it.
+type block struct {
+  key blockKey
+  mu sync.Mutex
+  data []byte
+}
+
+// BlockCache is an LRU of fixed-size blocks shared across every open file,
+// bounded by a total byte budget.
+type BlockCache struct {
+  mu sync.Mutex
+  budget int64
+  used int64
+  lru *list.List // Front = most recently used.
+  entries map[blockKey]*list.Element
+}
+
+// NewBlockCache creates a cache that holds at most budget bytes of block
+// data across every file opened against it.
+func NewBlockCache(budget int64) *BlockCache {
+  return &BlockCache{
+    budget: budget,
+    lru: list.New(),
+    entries: make(map[blockKey]*list.Element),
+  }
+}
+
+// Open returns a per-file view onto the shared cache, fetching misses
+// through fetch.
+func (c *BlockCache) Open(fetch Fetcher) *CachedFile {
+  return &CachedFile{cache: c, fetch: fetch, keys: make(map[int64]bool)}
+}
+
+// CachedFile is one open file's view onto a shared BlockCache.
+type CachedFile struct {
+  cache *BlockCache
+  fetch Fetcher
+
+  mu sync.Mutex
+  keys map[int64]bool // Block offsets belonging to this file, for invalidation.
+  hits uint64
+  misses uint64
+}
+
+// Read serves dest starting at off, rounding down to block boundaries and
+// stitching together as many blocks as needed. It returns fewer bytes than
+// len(dest) only at end of file.
+func (f *CachedFile) Read(dest []byte, off int64) (int, error) {
+  n := 0
+  for n < len(dest) {
+    blockOff := (off + int64(n)) / BlockSize * BlockSize
+    data, err := f.readBlock(blockOff)
+    if err != nil {
+      return n, err
+    }
+    within := int(off + int64(n) - blockOff)
+    if within >= len(data) {
+      break
+    }
+    copied := copy(dest[n:], data[within:])
+    n += copied
+    if within+copied < BlockSize {
+      break // Short block: end of file.
+    }
+  }
+  return n, nil
+}
+
+// readBlock returns the full block starting at blockOff, fetching and
+// caching it on miss.
+func (f *CachedFile) readBlock(blockOff int64) ([]byte, error) {
+  key := blockKey{file: f, offset: blockOff}
+
+  f.cache.mu.Lock()
+  elem, ok := f.cache.entries[key]
+  var b *block
+  if ok {
+    f.cache.lru.MoveToFront(elem)
+    b = elem.Value.(*block)
+    f.recordHit()
+  } else {
+    b = &block{key: key}
+    elem = f.cache.lru.PushFront(b)
+    f.cache.entries[key] = elem
+    f.addKey(blockOff)
+    f.recordMiss()
+  }
+  f.cache.mu.Unlock()
+
+  b.mu.Lock()
+  defer b.mu.Unlock()
+  if b.data == nil {
+    data, err := f.fetch.FetchBlock(blockOff)
+    if err != nil {
+      f.cache.evictBlock(b)
+      return nil, err
+    }
+    f.cache.commit(b, data)
+  }
+  return b.data, nil
+}
+
+// Invalidate drops this file's cached copy of the block containing off, if
+// any. Callers invoke this when they observe a length change via
+// GetFileLength or perform their own write.
+func (f *CachedFile) Invalidate(off int64) {
+  f.cache.evict(blockKey{file: f, offset: off / BlockSize * BlockSize})
+}
+
+// InvalidateRange drops every cached block overlapping [off, off+length)
+// from this handle's view. See blockKey for the known limitation that this
+// does not reach blocks cached by a different handle open on the same
+// path.
+func (f *CachedFile) InvalidateRange(off, length int64) {
+  if length <= 0 {
+    return
+  }
+  start := off / BlockSize * BlockSize
+  end := (off + length - 1) / BlockSize * BlockSize
+  for b := start; b <= end; b += BlockSize {
+    f.Invalidate(b)
+  }
+}
+
+// Close drops every block belonging to this file handle from the shared
+// cache.
+func (f *CachedFile) Close() {
+  f.mu.Lock()
+  offsets := make([]int64, 0, len(f.keys))
+  for off := range f.keys {
+    offsets = append(offsets, off)
+  }
+  f.mu.Unlock()
+  for _, off := range offsets {
+    f.Invalidate(off)
+  }
+}
+
+// Stats reports this file handle's hit/miss counters.
+func (f *CachedFile) Stats() (hits, misses uint64) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  return f.hits, f.misses
+}
+
+func (f *CachedFile) recordHit() {
+  f.mu.Lock()
+  f.hits++
+  f.mu.Unlock()
+}
+
+func (f *CachedFile) recordMiss() {
+  f.mu.Lock()
+  f.misses++
+  f.mu.Unlock()
+}
+
+func (f *CachedFile) addKey(off int64) {
+  f.mu.Lock()
+  f.keys[off] = true
+  f.mu.Unlock()
+}
+
+// Pre-condition: caller holds neither c.mu nor any block's mu.
+// evict drops whatever block currently sits at key, if any, accounting for
+// its size. Used by explicit invalidation (Invalidate/Close), where the
+// intent is "whatever is cached here now is no longer valid" regardless of
+// whether a fetch for it is still in flight.
+func (c *BlockCache) evict(key blockKey) {
+  c.mu.Lock()
+  elem, ok := c.entries[key]
+  if !ok {
+    c.mu.Unlock()
+    return
+  }
+  b := elem.Value.(*block)
+  c.lru.Remove(elem)
+  delete(c.entries, key)
+  c.used -= int64(len(b.data))
+  c.mu.Unlock()
+
+  key.file.mu.Lock()
+  delete(key.file.keys, key.offset)
+  key.file.mu.Unlock()
+}
+
+// Pre-condition: caller holds neither c.mu nor any block's mu.
+// evictBlock drops b, but only if it is still the current entry for its
+// key. A failed fetch calls this to clean up after itself; by the time the
+// fetch fails, a concurrent Invalidate/Close or LRU eviction may already
+// have dropped b (or even replaced it with a newer block at the same key),
+// and in that case there is nothing of b's left to account for or remove.
+func (c *BlockCache) evictBlock(b *block) {
+  c.mu.Lock()
+  elem, ok := c.entries[b.key]
+  if !ok || elem.Value.(*block) != b {
+    c.mu.Unlock()
+    return
+  }
+  c.lru.Remove(elem)
+  delete(c.entries, b.key)
+  c.used -= int64(len(b.data))
+  c.mu.Unlock()
+
+  b.key.file.mu.Lock()
+  delete(b.key.file.keys, b.key.offset)
+  b.key.file.mu.Unlock()
+}
+
+// Pre-condition: caller holds b.mu but not c.mu, and has just fetched data
+// for b.
+// commit stores data in b under c.mu, accounts its bytes and evicts
+// least-recently-used blocks until the cache is back within budget.
+// Setting b.data under c.mu means evict and evictLocked only ever see
+// bytes that commit has already accounted. If b is no longer the cache's
+// current entry for its key — a concurrent Invalidate/Close raced the
+// fetch and won — there is nothing to account for; the fetched data is
+// still returned to this caller, it's just not retained in the cache.
+func (c *BlockCache) commit(b *block, data []byte) {
+  c.mu.Lock()
+  b.data = data
+  elem, ok := c.entries[b.key]
+  if !ok || elem.Value.(*block) != b {
+    c.mu.Unlock()
+    return
+  }
+  c.used += int64(len(b.data))
+  evicted := c.evictLocked()
+  c.mu.Unlock()
+
+  for _, ev := range evicted {
+    ev.key.file.mu.Lock()
+    delete(ev.key.file.keys, ev.key.offset)
+    ev.key.file.mu.Unlock()
+  }
+}
+
+// Pre-condition: caller holds c.mu.
+// evictLocked walks the LRU from least- to most-recently-used, removing
+// completed blocks until the cache is back within budget. Blocks whose
+// fetch is still in flight (data == nil) are never removed — evicting one
+// would orphan it: the in-flight fetch would later account its bytes
+// against c.used for an entry no longer reachable through entries/lru,
+// permanently inflating c.used past budget. Such blocks are simply passed
+// over in favor of older, completed ones.
+func (c *BlockCache) evictLocked() []*block {
+  evicted := make([]*block, 0)
+  for elem := c.lru.Back(); elem != nil && c.used > c.budget; {
+    prev := elem.Prev()
+    candidate := elem.Value.(*block)
+    if candidate.data != nil {
+      c.lru.Remove(elem)
+      delete(c.entries, candidate.key)
+      c.used -= int64(len(candidate.data))
+      evicted = append(evicted, candidate)
+    }
+    elem = prev
+  }
+  return evicted
+}
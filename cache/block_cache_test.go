@@ -0,0 +1,156 @@
+package cache
+
+import (
+  "bytes"
+  "sync"
+  "sync/atomic"
+  "testing"
+)
+
+// fakeFetcher hands back a deterministic block of data for any aligned
+// offset and counts how many times FetchBlock was actually called, so
+// tests can assert on coalescing.
+type fakeFetcher struct {
+  fetches int64
+  ready chan struct{} // closed to let concurrent fetches proceed together.
+}
+
+func (f *fakeFetcher) FetchBlock(off int64) ([]byte, error) {
+  atomic.AddInt64(&f.fetches, 1)
+  if f.ready != nil {
+    <-f.ready
+  }
+  data := make([]byte, BlockSize)
+  for i := range data {
+    data[i] = byte(off + int64(i))
+  }
+  return data, nil
+}
+
+func TestCachedFileReadHitsAfterFirstFetch(t *testing.T) {
+  c := NewBlockCache(DefaultBudget)
+  fetcher := &fakeFetcher{}
+  f := c.Open(fetcher)
+
+  dest := make([]byte, BlockSize)
+  if _, err := f.Read(dest, 0); err != nil {
+    t.Fatalf("first Read: %v", err)
+  }
+  if _, err := f.Read(dest, 0); err != nil {
+    t.Fatalf("second Read: %v", err)
+  }
+  if got := atomic.LoadInt64(&fetcher.fetches); got != 1 {
+    t.Errorf("FetchBlock called %d times, want 1 (second read should hit the cache)", got)
+  }
+  hits, misses := f.Stats()
+  if hits != 1 || misses != 1 {
+    t.Errorf("Stats() = (hits=%d, misses=%d), want (1, 1)", hits, misses)
+  }
+}
+
+// TestReadBlockCoalescesConcurrentFetches exercises the per-block mutex
+// that's supposed to make concurrent readers of the same block share a
+// single network fetch instead of each racing to populate it. Run with
+// -race to also catch any data race in the LRU/accounting path.
+func TestReadBlockCoalescesConcurrentFetches(t *testing.T) {
+  c := NewBlockCache(DefaultBudget)
+  ready := make(chan struct{})
+  fetcher := &fakeFetcher{ready: ready}
+  f := c.Open(fetcher)
+
+  const readers = 16
+  var wg sync.WaitGroup
+  results := make([][]byte, readers)
+  for i := 0; i < readers; i++ {
+    wg.Add(1)
+    go func(i int) {
+      defer wg.Done()
+      dest := make([]byte, BlockSize)
+      if _, err := f.Read(dest, 0); err != nil {
+        t.Errorf("Read: %v", err)
+        return
+      }
+      results[i] = dest
+    }(i)
+  }
+  close(ready) // let every in-flight fetch proceed at once.
+  wg.Wait()
+
+  if got := atomic.LoadInt64(&fetcher.fetches); got != 1 {
+    t.Errorf("FetchBlock called %d times for %d concurrent readers of the same block, want 1",
+              got, readers)
+  }
+  for i, got := range results {
+    if !bytes.Equal(got, results[0]) {
+      t.Errorf("reader %d got different data than reader 0", i)
+    }
+  }
+}
+
+func TestInvalidateDropsCachedBlock(t *testing.T) {
+  c := NewBlockCache(DefaultBudget)
+  fetcher := &fakeFetcher{}
+  f := c.Open(fetcher)
+
+  dest := make([]byte, BlockSize)
+  if _, err := f.Read(dest, 0); err != nil {
+    t.Fatalf("Read: %v", err)
+  }
+  f.Invalidate(0)
+  if _, err := f.Read(dest, 0); err != nil {
+    t.Fatalf("Read after Invalidate: %v", err)
+  }
+  if got := atomic.LoadInt64(&fetcher.fetches); got != 2 {
+    t.Errorf("FetchBlock called %d times, want 2 (Invalidate should force a re-fetch)", got)
+  }
+}
+
+func TestCloseDropsOnlyThisHandlesBlocks(t *testing.T) {
+  c := NewBlockCache(DefaultBudget)
+  fetcherA := &fakeFetcher{}
+  fetcherB := &fakeFetcher{}
+  a := c.Open(fetcherA)
+  b := c.Open(fetcherB)
+
+  dest := make([]byte, BlockSize)
+  if _, err := a.Read(dest, 0); err != nil {
+    t.Fatalf("a.Read: %v", err)
+  }
+  if _, err := b.Read(dest, 0); err != nil {
+    t.Fatalf("b.Read: %v", err)
+  }
+  a.Close()
+
+  if _, err := b.Read(dest, 0); err != nil {
+    t.Fatalf("b.Read after a.Close: %v", err)
+  }
+  if got := atomic.LoadInt64(&fetcherB.fetches); got != 1 {
+    t.Errorf("handle b refetched %d times, want 1 (a.Close should not evict b's blocks)", got)
+  }
+}
+
+func TestBlockCacheEvictsLeastRecentlyUsedWithinBudget(t *testing.T) {
+  c := NewBlockCache(2 * BlockSize)
+  fetcher := &fakeFetcher{}
+  f := c.Open(fetcher)
+
+  dest := make([]byte, BlockSize)
+  for _, off := range []int64{0, BlockSize, 2 * BlockSize} {
+    if _, err := f.Read(dest, off); err != nil {
+      t.Fatalf("Read(off=%d): %v", off, err)
+    }
+  }
+  if c.used > c.budget {
+    t.Errorf("cache used %d bytes, over budget %d", c.used, c.budget)
+  }
+
+  // Block 0 was the least recently used when block 2*BlockSize was
+  // fetched, so it should have been evicted and need re-fetching.
+  before := atomic.LoadInt64(&fetcher.fetches)
+  if _, err := f.Read(dest, 0); err != nil {
+    t.Fatalf("Read(off=0) after eviction: %v", err)
+  }
+  if got := atomic.LoadInt64(&fetcher.fetches); got != before+1 {
+    t.Errorf("FetchBlock called %d times re-reading evicted block 0, want %d", got, before+1)
+  }
+}
@@ -0,0 +1,31 @@
+// Command simplegfs-mount mounts a simplegfs cluster's namespace as a POSIX
+// filesystem, so ordinary tools (cp, tar, editors) can operate on it
+// without linking against the RPC client API.
+package main
+
+import (
+  "flag"
+  log "github.com/Sirupsen/logrus"
+
+  "github.com/hanwen/go-fuse/v2/fuse/nodefs"
+  gfsfuse "github.com/wweiw/simplegfs/fuse"
+)
+
+func main() {
+  master := flag.String("master", "", "address of the simplegfs MasterServer, e.g. localhost:7777")
+  mountpoint := flag.String("mountpoint", "", "directory to mount the simplegfs namespace on")
+  flag.Parse()
+
+  if *master == "" || *mountpoint == "" {
+    log.Fatal("usage: simplegfs-mount -master host:port -mountpoint /path")
+  }
+
+  root := gfsfuse.NewRoot(*master)
+  server, _, err := nodefs.MountRoot(*mountpoint, root, &nodefs.Options{})
+  if err != nil {
+    log.Fatal("mount failed: ", err)
+  }
+
+  log.Infoln("simplegfs mounted on", *mountpoint, "backed by master", *master)
+  server.Serve()
+}
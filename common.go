@@ -0,0 +1,27 @@
+package simplegfs
+
+import "time"
+
+const (
+  // ChunkSize is the fixed size, in bytes, of a single chunk.
+  ChunkSize = 64 * 1024 * 1024
+
+  // FilePermRW is the permission bits used when the master persists its
+  // metadata files to disk.
+  FilePermRW = 0644
+
+  // HeartbeatInterval is how often the master runs its background tick.
+  HeartbeatInterval = 100 * time.Millisecond
+
+  // ChunkServerTimeout is how long the master will tolerate not hearing a
+  // heartbeat from a chunk server before treating it as dead.
+  ChunkServerTimeout = 1 * time.Second
+
+  // DefaultReplicationTarget is the number of live replicas tick() tries to
+  // maintain for every chunk, absent an override.
+  DefaultReplicationTarget = 3
+
+  // CompactionInterval is how often the master asks ChunkManager to write
+  // a fresh gob snapshot and truncate its operation log.
+  CompactionInterval = 30 * time.Second
+)
@@ -0,0 +1,132 @@
+// Package fuse exposes the GFS namespace as a POSIX filesystem using
+// github.com/hanwen/go-fuse/v2, so unmodified tools (cp, tar, editors) can
+// operate against a simplegfs cluster without linking against the RPC
+// client API.
+package fuse
+
+import (
+  "net/rpc"
+  "sync"
+
+  simplegfs "github.com/wweiw/simplegfs"
+)
+
+// rpcClient is a lazily-dialed, reconnect-on-error wrapper around
+// net/rpc.Client, shared by every node that needs to reach the same
+// address (the master, or a chunk server).
+type rpcClient struct {
+  addr string
+  mu sync.Mutex
+  client *rpc.Client
+}
+
+func newRpcClient(addr string) *rpcClient {
+  return &rpcClient{addr: addr}
+}
+
+func (c *rpcClient) call(rpcName string, args, reply interface{}) error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if c.client == nil {
+    client, err := rpc.Dial("tcp", c.addr)
+    if err != nil {
+      return err
+    }
+    c.client = client
+  }
+  err := c.client.Call(rpcName, args, reply)
+  if err != nil {
+    // The connection may be dead; drop it so the next call redials.
+    c.client.Close()
+    c.client = nil
+  }
+  return err
+}
+
+// masterClient is the subset of master RPCs the FUSE layer needs.
+type masterClient struct {
+  rpc *rpcClient
+}
+
+func newMasterClient(addr string) *masterClient {
+  return &masterClient{rpc: newRpcClient(addr)}
+}
+
+func (m *masterClient) list(path string) ([]string, error) {
+  reply := &simplegfs.ListReply{}
+  err := m.rpc.call("MasterServer.List", path, reply)
+  return reply.Paths, err
+}
+
+func (m *masterClient) create(path string) error {
+  var reply bool
+  return m.rpc.call("MasterServer.Create", path, &reply)
+}
+
+func (m *masterClient) mkdir(path string) error {
+  var reply bool
+  return m.rpc.call("MasterServer.Mkdir", path, &reply)
+}
+
+func (m *masterClient) getFileLength(path string) (int64, error) {
+  var length int64
+  err := m.rpc.call("MasterServer.GetFileLength", path, &length)
+  return length, err
+}
+
+func (m *masterClient) findLocations(path string, chunkIndex uint64) (*simplegfs.FindLocationsReply, error) {
+  args := simplegfs.FindLocationsArgs{Path: path, ChunkIndex: chunkIndex}
+  reply := &simplegfs.FindLocationsReply{}
+  err := m.rpc.call("MasterServer.FindLocations", args, reply)
+  return reply, err
+}
+
+func (m *masterClient) addChunk(path string, chunkIndex uint64) (*simplegfs.AddChunkReply, error) {
+  args := simplegfs.AddChunkArgs{Path: path, ChunkIndex: chunkIndex}
+  reply := &simplegfs.AddChunkReply{}
+  err := m.rpc.call("MasterServer.AddChunk", args, reply)
+  return reply, err
+}
+
+func (m *masterClient) findLeaseHolder(chunkHandle uint64) (*simplegfs.FindLeaseHolderReply, error) {
+  args := simplegfs.FindLeaseHolderArgs{ChunkHandle: chunkHandle}
+  reply := &simplegfs.FindLeaseHolderReply{}
+  err := m.rpc.call("MasterServer.FindLeaseHolder", args, reply)
+  return reply, err
+}
+
+// chunkServerClients caches one rpcClient per chunk server address so reads
+// and writes against the same server reuse a connection.
+type chunkServerClients struct {
+  mu sync.Mutex
+  byAddr map[string]*rpcClient
+}
+
+func newChunkServerClients() *chunkServerClients {
+  return &chunkServerClients{byAddr: make(map[string]*rpcClient)}
+}
+
+func (c *chunkServerClients) get(addr string) *rpcClient {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  client, ok := c.byAddr[addr]
+  if !ok {
+    client = newRpcClient(addr)
+    c.byAddr[addr] = client
+  }
+  return client
+}
+
+func (c *chunkServerClients) read(addr string, handle uint64, offset int64, length int) ([]byte, error) {
+  args := simplegfs.ReadChunkArgs{ChunkHandle: handle, Offset: offset, Length: length}
+  reply := &simplegfs.ReadChunkReply{}
+  err := c.get(addr).call("ChunkServer.Read", args, reply)
+  return reply.Data, err
+}
+
+func (c *chunkServerClients) write(addr string, handle uint64, offset int64, data []byte) (int, error) {
+  args := simplegfs.WriteChunkArgs{ChunkHandle: handle, Offset: offset, Data: data}
+  reply := &simplegfs.WriteChunkReply{}
+  err := c.get(addr).call("ChunkServer.Write", args, reply)
+  return reply.Length, err
+}
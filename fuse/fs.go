@@ -0,0 +1,258 @@
+package fuse
+
+import (
+  "path/filepath"
+
+  "github.com/hanwen/go-fuse/v2/fuse"
+  "github.com/hanwen/go-fuse/v2/fuse/nodefs"
+  simplegfs "github.com/wweiw/simplegfs"
+  "github.com/wweiw/simplegfs/cache"
+)
+
+// NewRoot builds the root node of a simplegfs mount. masterAddr is the
+// "host:port" of the MasterServer to back the tree with.
+func NewRoot(masterAddr string) nodefs.Node {
+  return &gfsNode{
+    Node: nodefs.NewDefaultNode(),
+    fs: &gfsFs{
+      master: newMasterClient(masterAddr),
+      chunkServers: newChunkServerClients(),
+      blocks: cache.NewBlockCache(cache.DefaultBudget),
+    },
+    path: "/",
+  }
+}
+
+// gfsFs holds the RPC clients and block cache shared by every node in the
+// tree.
+type gfsFs struct {
+  master *masterClient
+  chunkServers *chunkServerClients
+  blocks *cache.BlockCache
+}
+
+// gfsNode is one file or directory in the mounted GFS namespace. Every
+// lookup re-resolves state from the master rather than caching it, since
+// the master is the only source of truth for the namespace.
+type gfsNode struct {
+  nodefs.Node
+  fs *gfsFs
+  path string // Absolute GFS path, e.g. "/foo/bar".
+}
+
+// var _ nodefs.Node asserts gfsNode satisfies the interface at compile
+// time, so an override of one of nodefs.Node's methods (like OpenDir) with
+// the right name but the wrong signature fails the build. A misnamed
+// method is not caught: the embedded default still supplies the real one.
+var _ nodefs.Node = (*gfsNode)(nil)
+
+func childPath(parent, name string) string {
+  if parent == "/" {
+    return "/" + name
+  }
+  return parent + "/" + name
+}
+
+// Lookup resolves name within this directory by listing it through the
+// master and checking whether name is present.
+func (n *gfsNode) Lookup(out *fuse.Attr, name string, context *fuse.Context) (*nodefs.Inode, fuse.Status) {
+  entries, err := n.fs.master.list(n.path)
+  if err != nil {
+    return nil, fuse.EIO
+  }
+  found := false
+  for _, e := range entries {
+    if filepath.Base(e) == name {
+      found = true
+      break
+    }
+  }
+  if !found {
+    return nil, fuse.ENOENT
+  }
+  child := &gfsNode{
+    Node: nodefs.NewDefaultNode(),
+    fs: n.fs,
+    path: childPath(n.path, name),
+  }
+  if code := child.GetAttr(out, nil, context); !code.Ok() {
+    return nil, code
+  }
+  return n.Inode().NewChild(name, out.IsDir(), child), fuse.OK
+}
+
+// OpenDir lists this directory's children via MasterServer.List.
+func (n *gfsNode) OpenDir(context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+  entries, err := n.fs.master.list(n.path)
+  if err != nil {
+    return nil, fuse.EIO
+  }
+  result := make([]fuse.DirEntry, 0, len(entries))
+  for _, e := range entries {
+    result = append(result, fuse.DirEntry{Name: filepath.Base(e)})
+  }
+  return result, fuse.OK
+}
+
+// GetAttr reports a file's size via MasterServer.GetFileLength. Directories
+// are distinguished by having no file length to report; simplegfs does not
+// track a separate mode bit for them in this snapshot, so we infer
+// directory-ness from the namespace listing instead of GetFileLength.
+func (n *gfsNode) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+  if n.path == "/" {
+    out.Mode = fuse.S_IFDIR | 0755
+    return fuse.OK
+  }
+  length, err := n.fs.master.getFileLength(n.path)
+  if err == nil {
+    out.Mode = fuse.S_IFREG | 0644
+    out.Size = uint64(length)
+    return fuse.OK
+  }
+  // Not a file MasterServer knows the length of; assume it's a directory
+  // and confirm by listing it.
+  if _, err := n.fs.master.list(n.path); err != nil {
+    return fuse.ENOENT
+  }
+  out.Mode = fuse.S_IFDIR | 0755
+  return fuse.OK
+}
+
+// Mkdir creates a new directory via MasterServer.Mkdir.
+func (n *gfsNode) Mkdir(name string, mode uint32, context *fuse.Context) (*nodefs.Inode, fuse.Status) {
+  path := childPath(n.path, name)
+  if err := n.fs.master.mkdir(path); err != nil {
+    return nil, fuse.EIO
+  }
+  child := &gfsNode{Node: nodefs.NewDefaultNode(), fs: n.fs, path: path}
+  return n.Inode().NewChild(name, true, child), fuse.OK
+}
+
+// Create creates a new file via MasterServer.Create and opens it for
+// reading and writing.
+func (n *gfsNode) Create(name string, flags uint32, mode uint32,
+                         context *fuse.Context) (nodefs.File, *nodefs.Inode, fuse.Status) {
+  path := childPath(n.path, name)
+  if err := n.fs.master.create(path); err != nil {
+    return nil, nil, fuse.EIO
+  }
+  child := &gfsNode{Node: nodefs.NewDefaultNode(), fs: n.fs, path: path}
+  inode := n.Inode().NewChild(name, false, child)
+  return newGfsFile(child), inode, fuse.OK
+}
+
+// Open returns a handle for reading and writing an existing file.
+func (n *gfsNode) Open(flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+  return newGfsFile(n), fuse.OK
+}
+
+// gfsFile implements nodefs.File, translating byte offsets into chunk
+// indices and talking directly to chunk servers for the data path. Reads
+// go through a per-handle block cache shared with every other open file on
+// this mount.
+type gfsFile struct {
+  nodefs.File
+  node *gfsNode
+  cached *cache.CachedFile
+  lastLength int64
+}
+
+func newGfsFile(node *gfsNode) *gfsFile {
+  f := &gfsFile{File: nodefs.NewDefaultFile(), node: node}
+  f.cached = node.fs.blocks.Open(f)
+  return f
+}
+
+// FetchBlock implements cache.Fetcher by finding the block's owning chunk
+// and reading it in full from one of its replicas.
+func (f *gfsFile) FetchBlock(off int64) ([]byte, error) {
+  chunkIndex := uint64(off / simplegfs.ChunkSize)
+  chunkOffset := off % simplegfs.ChunkSize
+  locs, err := f.node.fs.master.findLocations(f.node.path, chunkIndex)
+  if err != nil || len(locs.ChunkLocations) == 0 {
+    return nil, err
+  }
+  return f.node.fs.chunkServers.read(locs.ChunkLocations[0], locs.ChunkHandle, chunkOffset, cache.BlockSize)
+}
+
+// Read serves dest out of the block cache, invalidating it first if the
+// file's length has changed since this handle last observed it.
+func (f *gfsFile) Read(dest []byte, off int64) (fuse.ReadResult, fuse.Status) {
+  f.refreshLength()
+  n, err := f.cached.Read(dest, off)
+  if err != nil {
+    return nil, fuse.EIO
+  }
+  return fuse.ReadResultData(dest[:n]), fuse.OK
+}
+
+// Write serves a write by finding or allocating the owning chunk and
+// sending the data to its current lease holder, then drops any cached
+// blocks the write touched.
+func (f *gfsFile) Write(data []byte, off int64) (uint32, fuse.Status) {
+  var written uint32
+  for len(data) > 0 {
+    chunkOffset := off % simplegfs.ChunkSize
+    chunkLen := data
+    if remaining := simplegfs.ChunkSize - chunkOffset; int64(len(chunkLen)) > remaining {
+      chunkLen = chunkLen[:remaining]
+    }
+    n, status := f.writeChunk(chunkLen, off, chunkOffset)
+    written += uint32(n)
+    if !status.Ok() {
+      return written, status
+    }
+    data = data[n:]
+    off += int64(n)
+    if n < len(chunkLen) {
+      // Short write on this chunk; don't advance into the next one.
+      break
+    }
+  }
+  return written, fuse.OK
+}
+
+// writeChunk writes data, which must fit within a single chunk starting at
+// chunkOffset, to the chunk owning absolute offset off.
+func (f *gfsFile) writeChunk(data []byte, off, chunkOffset int64) (int, fuse.Status) {
+  chunkIndex := uint64(off / simplegfs.ChunkSize)
+  locs, err := f.node.fs.master.findLocations(f.node.path, chunkIndex)
+  if err != nil {
+    added, err := f.node.fs.master.addChunk(f.node.path, chunkIndex)
+    if err != nil {
+      return 0, fuse.EIO
+    }
+    locs = &simplegfs.FindLocationsReply{
+      ChunkHandle: added.ChunkHandle,
+      ChunkLocations: added.ChunkLocations,
+    }
+  }
+  lease, err := f.node.fs.master.findLeaseHolder(locs.ChunkHandle)
+  if err != nil || lease.Primary == "" {
+    return 0, fuse.EIO
+  }
+  n, err := f.node.fs.chunkServers.write(lease.Primary, locs.ChunkHandle, chunkOffset, data)
+  if err != nil {
+    return 0, fuse.EIO
+  }
+  f.cached.InvalidateRange(off, int64(n))
+  return n, fuse.OK
+}
+
+// refreshLength drops every cached block for this handle if the file's
+// length has changed since the last time this handle checked.
+func (f *gfsFile) refreshLength() {
+  length, err := f.node.fs.master.getFileLength(f.node.path)
+  if err != nil {
+    return
+  }
+  if length != f.lastLength {
+    f.cached.Close()
+  }
+  f.lastLength = length
+}
+
+// Release drops this handle's blocks from the shared cache.
+func (f *gfsFile) Release() {
+  f.cached.Close()
+}
@@ -24,7 +24,12 @@ type MasterServer struct {
   // Filename of a file that contains MasterServer metadata
   serverMeta string
 
-  chunkservers map[string]time.Time
+  // Filename of the chunk manager's gob snapshot; its operation log lives
+  // alongside it at chunkMeta+".wal".
+  chunkMeta string
+
+  // Number of live replicas tick() tries to maintain for every chunk.
+  replicationTarget int
 
   // Namespace manager
   namespaceManager *master.NamespaceManager
@@ -46,7 +51,7 @@ type locationsAndLease struct {
 // and chunkservers.
 func (ms *MasterServer) Heartbeat(args *HeartbeatArgs,
                                   reply *HeartbeatReply) error {
-  ms.chunkservers[args.Addr] = time.Now()
+  ms.chunkManager.UpdateServerInfo(args.Addr, args.Capacity, args.Used, args.ChunkHandles)
   if len(args.PendingExtensions) > 0 {
     ms.csExtendLease(args.Addr, args.PendingExtensions)
   }
@@ -160,15 +165,20 @@ func (ms *MasterServer) AddChunk(args AddChunkArgs,
   return nil
 }
 
-// Chunk server calls ReportChunk to tell the master
-// they have a certain chunk and the number of defined bytes in
-// the chunk.
+// Chunk server calls ReportChunk to tell the master they have a certain
+// chunk and the number of defined bytes in the chunk, or, when Deleted is
+// set, that they have discarded it. The latter lets the master prune its
+// view of that chunk's locations instead of waiting for it to go stale.
 func (ms *MasterServer) ReportChunk(args ReportChunkArgs,
                                     reply *ReportChunkReply) error {
   log.Debugln("MasterServer: Report Chunk.")
-  length := args.Length
   handle := args.ChunkHandle
   server := args.ServerAddress
+  if args.Deleted {
+    ms.chunkManager.RemoveChunkLocation(handle, server)
+    return nil
+  }
+  length := args.Length
   pathIndex, err := ms.chunkManager.GetPathIndexFromHandle(handle)
   if err != nil {
     return err
@@ -188,6 +198,43 @@ func (ms *MasterServer) ReportChunk(args ReportChunkArgs,
   return nil
 }
 
+// RegisterChunkserver is called once by a chunk server on startup to
+// declare the rack (and, if reported, zone) it lives in and its total
+// capacity, so the placement policy can spread replicas across failure
+// domains.
+func (ms *MasterServer) RegisterChunkserver(args *RegisterChunkserverArgs,
+                                            reply *RegisterChunkserverReply) error {
+  ms.chunkManager.RegisterServer(args.Addr, args.Rack, args.Zone, args.Capacity)
+  return nil
+}
+
+// ListChunkservers returns the master's topology view of every known
+// chunk server, for tooling.
+func (ms *MasterServer) ListChunkservers(args *struct{}, reply *ListChunkserversReply) error {
+  servers := ms.chunkManager.ListServers()
+  reply.Servers = make([]ChunkserverInfo, len(servers))
+  for i, s := range servers {
+    reply.Servers[i] = ChunkserverInfo{
+      Addr: s.Addr,
+      Rack: s.Rack,
+      Zone: s.Zone,
+      Capacity: s.Capacity,
+      Used: s.Used,
+      Alive: s.Alive,
+    }
+  }
+  return nil
+}
+
+// Stats reports counters about the master's background re-replication
+// subsystem, for monitoring.
+func (ms *MasterServer) Stats(args *struct{}, reply *StatsReply) error {
+  reply.ReReplicationsStarted = ms.chunkManager.ReplicationsStarted()
+  reply.ReReplicationsInFlight = ms.chunkManager.ReplicationsInFlight()
+  reply.DeadChunkServers = len(ms.chunkManager.DeadServers())
+  return nil
+}
+
 func (ms *MasterServer) GetFileLength(args string, reply *int64) error {
   log.Debugln("MasterServer: GetFileLength")
   length, err := ms.namespaceManager.GetFileLength(args)
@@ -209,14 +256,16 @@ func StartMasterServer(me string, servers []string) *MasterServer {
   ms := &MasterServer{
     me: me,
     serverMeta: "serverMeta" + me,
+    chunkMeta: "chunkMeta" + me,
     clientId: 1,
     chunkhandle: 1,
-    chunkservers: make(map[string]time.Time),
+    replicationTarget: DefaultReplicationTarget,
     namespaceManager: master.NewNamespaceManager(),
     chunkManager: master.NewChunkManager(servers),
   }
 
   loadServerMeta(ms)
+  ms.chunkManager.Load(ms.chunkMeta)
 
   rpcs := rpc.NewServer()
   rpcs.Register(ms)
@@ -250,16 +299,66 @@ func StartMasterServer(me string, servers []string) *MasterServer {
     }
   }()
 
+  // Periodically checkpoint the chunk manager's operation log into a fresh
+  // gob snapshot so it doesn't grow without bound.
+  go func() {
+    for ms.dead == false {
+      time.Sleep(CompactionInterval)
+      ms.chunkManager.Store(ms.chunkMeta)
+    }
+  }()
+
   return ms
 }
 
 
 // Helper functions
 
-// tick() is called once per PingInterval to
-// handle background tasks
+// tick() is called once per HeartbeatInterval to handle background tasks: it
+// finds chunk servers that have gone quiet, prunes them from every
+// chunk's locations, and kicks off a re-replication job for any chunk
+// that consequently dropped below ms.replicationTarget live replicas.
 func (ms *MasterServer) tick() {
-  // TODO: Scan in-memory data structures to find dead chunk servers
+  dead := ms.chunkManager.DeadServers()
+  underReplicated := ms.chunkManager.UnderReplicated(ms.replicationTarget, dead)
+  for _, chunk := range underReplicated {
+    if len(chunk.Replicas) == 0 {
+      log.Debugln("tick: no live replicas left for chunk", chunk.Handle, "; cannot re-replicate")
+      continue
+    }
+    destination, err := ms.chunkManager.PickDestination(chunk.Replicas)
+    if err != nil {
+      log.Debugln("tick: no destination available to re-replicate chunk", chunk.Handle, ":", err)
+      continue
+    }
+    source := chunk.Replicas[0]
+    ms.chunkManager.BeginReplication(chunk.Handle, source, destination)
+    go ms.replicateChunk(chunk.Handle, source, destination)
+  }
+}
+
+// replicateChunk asks source to stream handle to destination and, once the
+// chunk server reports back, clears the in-flight job and records the
+// result.
+func (ms *MasterServer) replicateChunk(handle uint64, source, destination string) {
+  args := &ReplicateChunkArgs{ChunkHandle: handle, Destination: destination}
+  reply := &ReplicateChunkReply{}
+  err := callChunkServer(source, "ChunkServer.ReplicateChunk", args, reply)
+  if err != nil {
+    log.Debugln("replicateChunk: failed to replicate chunk", handle, "from", source,
+                "to", destination, ":", err)
+  }
+  ms.chunkManager.FinishReplication(handle, destination, err == nil)
+}
+
+// callChunkServer places a synchronous RPC against a chunk server.
+func callChunkServer(addr, rpcName string, args interface{}, reply interface{}) error {
+  client, err := rpc.Dial("tcp", addr)
+  if err != nil {
+    return err
+  }
+  defer client.Close()
+  return client.Call(rpcName, args, reply)
 }
 
 // storeServerMeta stores master server's meta data persistently.
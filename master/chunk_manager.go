@@ -8,9 +8,22 @@ import (
   "io/ioutil"
   "log"
   "math/rand"
+  "os"
   "sync"
+  "time"
 )
 
+// chunkServerTimeout is how long the manager waits without a heartbeat
+// before it stops considering a chunk server a placement candidate.
+const chunkServerTimeout = 1 * time.Second
+
+// filePermRW is the permission bits used when package master persists its
+// metadata files to disk. Package simplegfs has its own copy of this
+// constant (FilePermRW, in common.go) for its own file writes; master
+// can't import the root package to reuse it without an import cycle
+// (simplegfs already imports master), so it's duplicated here.
+const filePermRW = 0644
+
 // Persistent information of a specific chunk.
 type Chunk struct {
   ChunkHandle uint64
@@ -28,6 +41,29 @@ type PathIndex struct {
   Index uint64
 }
 
+// serverInfo is the manager's in-memory view of a chunk server. Rack and
+// Zone are set once at registration; Capacity, Used and ChunkHandles are
+// kept up to date by heartbeats.
+type serverInfo struct {
+  Rack string // Failure domain the server sits in, reported at registration.
+  Zone string // Broader failure domain than Rack; may be empty.
+  Capacity int64 // Total disk space, in bytes, reported by the server.
+  Used int64 // Disk space in use, in bytes, reported by the server.
+  ChunkHandles []uint64 // Chunks the server last reported holding.
+  LastHeartbeat time.Time // Zero until the server's first heartbeat arrives.
+}
+
+// ServerSnapshot is a point-in-time, race-free view of one chunk server,
+// returned by ChunkManager.ListServers.
+type ServerSnapshot struct {
+  Addr string
+  Rack string
+  Zone string
+  Capacity int64
+  Used int64
+  Alive bool
+}
+
 type ChunkManager struct {
   lock sync.RWMutex // Read write lock.
   chunkHandle uint64 // Increment by 1 when a new chunk is created.
@@ -41,7 +77,33 @@ type ChunkManager struct {
   // chunk handle -> chunk locations (in-memory)
   locations map[uint64]*ChunkInfo
 
-  chunkServers []string // chunk servers
+  // chunk server address -> capacity/usage/last heartbeat (in-memory)
+  servers map[string]*serverInfo
+
+  // chunk handle -> in-flight re-replication job (in-memory)
+  replications map[uint64]*replicationJob
+
+  // Total number of re-replication jobs started since the manager came up.
+  replicationsStarted uint64
+
+  // wal is the operation log mutations are appended to between snapshots.
+  // Nil until Load has been called with a path to persist to.
+  wal *Wal
+}
+
+// replicationJob tracks an in-flight re-replication so tick doesn't queue a
+// second copy for a chunk that is already being restored.
+type replicationJob struct {
+  Source string
+  Destination string
+  Started time.Time
+}
+
+// UnderReplicatedChunk is a chunk whose live replica count has dropped
+// below the target and is not already being re-replicated.
+type UnderReplicatedChunk struct {
+  Handle uint64
+  Replicas []string // Surviving replicas, source candidates for the copy.
 }
 
 func NewChunkManager(servers []string) *ChunkManager {
@@ -50,11 +112,212 @@ func NewChunkManager(servers []string) *ChunkManager {
     chunks: make(map[string](map[uint64]*Chunk)),
     handles: make(map[uint64]*PathIndex),
     locations: make(map[uint64]*ChunkInfo),
-    chunkServers: servers,
+    servers: make(map[string]*serverInfo),
+    replications: make(map[uint64]*replicationJob),
+  }
+  // Servers are known by address up front but are not placement candidates
+  // until they send their first heartbeat.
+  for _, addr := range servers {
+    m.servers[addr] = &serverInfo{}
   }
   return m
 }
 
+// UpdateServerInfo records a chunk server's latest reported capacity, usage
+// and chunk list, then reconciles m.locations against that chunk list so
+// chunks the server no longer reports holding are pruned from its known
+// locations. Called by the master's Heartbeat RPC handler.
+func (m *ChunkManager) UpdateServerInfo(addr string, capacity, used int64, handles []uint64) {
+  m.lock.Lock()
+  defer m.lock.Unlock()
+  info, ok := m.servers[addr]
+  if !ok {
+    info = &serverInfo{}
+    m.servers[addr] = info
+  }
+  info.Capacity = capacity
+  info.Used = used
+  info.ChunkHandles = handles
+  info.LastHeartbeat = time.Now()
+  m.reconcileLocations(addr, handles)
+}
+
+// Pre-condition: call m.lock.Lock()
+// reconcileLocations drops addr from every chunk's known locations that
+// addr did not include in handles, its latest heartbeat-reported chunk
+// list. This catches a chunk server silently losing a chunk (disk failure,
+// manual cleanup) without waiting on it to notice and call ReportChunk with
+// Deleted set, the same role a heartbeat-reported block list plays in
+// SeaweedFS.
+func (m *ChunkManager) reconcileLocations(addr string, handles []uint64) {
+  reported := make(map[uint64]bool, len(handles))
+  for _, h := range handles {
+    reported[h] = true
+  }
+  for handle, info := range m.locations {
+    if reported[handle] {
+      continue
+    }
+    info.Locations = remove(info.Locations, addr)
+  }
+}
+
+// RegisterServer records a chunk server's placement topology: the rack (and,
+// if the deployment reports one, zone) it lives in, and its total
+// capacity. Called once by the master's RegisterChunkserver RPC handler
+// when a chunk server starts up.
+func (m *ChunkManager) RegisterServer(addr, rack, zone string, capacity int64) {
+  m.lock.Lock()
+  defer m.lock.Unlock()
+  info, ok := m.servers[addr]
+  if !ok {
+    info = &serverInfo{}
+    m.servers[addr] = info
+  }
+  info.Rack = rack
+  info.Zone = zone
+  info.Capacity = capacity
+}
+
+// ListServers returns a snapshot of every known chunk server's topology
+// and usage, for tooling.
+func (m *ChunkManager) ListServers() []ServerSnapshot {
+  m.lock.RLock()
+  defer m.lock.RUnlock()
+  now := time.Now()
+  result := make([]ServerSnapshot, 0, len(m.servers))
+  for addr, info := range m.servers {
+    result = append(result, ServerSnapshot{
+      Addr: addr,
+      Rack: info.Rack,
+      Zone: info.Zone,
+      Capacity: info.Capacity,
+      Used: info.Used,
+      Alive: !info.LastHeartbeat.IsZero() && now.Sub(info.LastHeartbeat) <= chunkServerTimeout,
+    })
+  }
+  return result
+}
+
+// RemoveChunkLocation drops addr from a chunk's known locations. Called when
+// a chunk server reports that it has discarded a chunk.
+func (m *ChunkManager) RemoveChunkLocation(handle uint64, addr string) {
+  m.lock.Lock()
+  defer m.lock.Unlock()
+  info, ok := m.locations[handle]
+  if !ok {
+    return
+  }
+  info.Locations = remove(info.Locations, addr)
+}
+
+// DeadServers returns the chunk servers that have not heartbeated within
+// chunkServerTimeout.
+func (m *ChunkManager) DeadServers() []string {
+  m.lock.RLock()
+  defer m.lock.RUnlock()
+  now := time.Now()
+  dead := make([]string, 0)
+  for addr, info := range m.servers {
+    if info.LastHeartbeat.IsZero() || now.Sub(info.LastHeartbeat) > chunkServerTimeout {
+      dead = append(dead, addr)
+    }
+  }
+  return dead
+}
+
+// UnderReplicated prunes deadServers from every chunk's known locations and
+// returns the chunks whose live replica count falls below target, skipping
+// any that already have a re-replication job in flight.
+func (m *ChunkManager) UnderReplicated(target int, deadServers []string) []UnderReplicatedChunk {
+  dead := make(map[string]bool, len(deadServers))
+  for _, addr := range deadServers {
+    dead[addr] = true
+  }
+  m.lock.Lock()
+  defer m.lock.Unlock()
+  result := make([]UnderReplicatedChunk, 0)
+  for handle, info := range m.locations {
+    live := make([]string, 0, len(info.Locations))
+    for _, addr := range info.Locations {
+      if !dead[addr] {
+        live = append(live, addr)
+      }
+    }
+    info.Locations = live
+    if len(live) >= target {
+      continue
+    }
+    if _, inFlight := m.replications[handle]; inFlight {
+      continue
+    }
+    result = append(result, UnderReplicatedChunk{Handle: handle, Replicas: live})
+  }
+  return result
+}
+
+// BeginReplication records an in-flight re-replication job for handle so
+// tick won't queue a duplicate copy while it is outstanding.
+func (m *ChunkManager) BeginReplication(handle uint64, source, destination string) {
+  m.lock.Lock()
+  defer m.lock.Unlock()
+  m.replications[handle] = &replicationJob{
+    Source: source,
+    Destination: destination,
+    Started: time.Now(),
+  }
+  m.replicationsStarted++
+}
+
+// FinishReplication clears handle's in-flight job and, on success, records
+// destination as a new location for the chunk.
+func (m *ChunkManager) FinishReplication(handle uint64, destination string, success bool) {
+  m.lock.Lock()
+  defer m.lock.Unlock()
+  delete(m.replications, handle)
+  if !success {
+    return
+  }
+  info, ok := m.locations[handle]
+  if !ok {
+    info = &ChunkInfo{Handle: handle, Locations: make([]string, 0)}
+    m.locations[handle] = info
+  }
+  info.Locations = insert(info.Locations, destination)
+}
+
+// ReplicationsInFlight returns the number of re-replication jobs currently
+// outstanding.
+func (m *ChunkManager) ReplicationsInFlight() int {
+  m.lock.RLock()
+  defer m.lock.RUnlock()
+  return len(m.replications)
+}
+
+// ReplicationsStarted returns the total number of re-replication jobs
+// started since the manager came up.
+func (m *ChunkManager) ReplicationsStarted() uint64 {
+  m.lock.RLock()
+  defer m.lock.RUnlock()
+  return m.replicationsStarted
+}
+
+// PickDestination chooses a single live chunk server for a re-replication
+// copy, excluding servers that already hold the chunk, using the same
+// capacity-weighted, rack/zone-diverse policy as AddChunk: the racks/zones
+// of exclude's surviving replicas are seeded as already-used so the
+// replacement doesn't land back in a rack the chunk just lost one of its
+// copies from.
+func (m *ChunkManager) PickDestination(exclude []string) (string, error) {
+  m.lock.RLock()
+  defer m.lock.RUnlock()
+  picked, err := m.pickReplicas(1, exclude)
+  if err != nil {
+    return "", err
+  }
+  return picked[0], nil
+}
+
 // Find chunk server locations associated given a file name and a chunk index.
 func (m *ChunkManager) FindLocations(path string, chunkIndex uint64) (*ChunkInfo, error) {
   m.lock.Lock()
@@ -62,8 +325,8 @@ func (m *ChunkManager) FindLocations(path string, chunkIndex uint64) (*ChunkInfo
   return m.getChunkInfo(path, chunkIndex)
 }
 
-// Allocate a new chunk handle and three random chunk servers
-// for a given file's chunk.
+// Allocate a new chunk handle and pick three chunk servers, favoring
+// servers with the most free capacity, for a given file's chunk.
 func (m *ChunkManager) AddChunk(path string, chunkIndex uint64) (*ChunkInfo, error) {
   m.lock.Lock()
   defer m.lock.Unlock()
@@ -131,11 +394,15 @@ type persistentData struct {
   Handles *map[uint64]*PathIndex
 }
 
-// Store current chunk handle into path.
-// Store (file, chunk index) -> chunk information into path.
+// Store takes a compaction checkpoint: it gob-encodes the current
+// (chunkHandle, chunks, handles) state to path, then truncates the
+// operation log opened by Load, since every mutation up to this point is
+// now captured in the snapshot. Takes the full lock, not just a read lock,
+// so no addChunk can append to the log between the snapshot and the
+// truncate.
 func (m *ChunkManager) Store(path string) {
-  m.lock.RLock()
-  defer m.lock.RUnlock()
+  m.lock.Lock()
+  defer m.lock.Unlock()
   var data bytes.Buffer
   enc := gob.NewEncoder(&data)
   err := enc.Encode(&persistentData{
@@ -146,20 +413,46 @@ func (m *ChunkManager) Store(path string) {
   if err != nil {
     log.Fatal("encode error:", err)
   }
-  err = ioutil.WriteFile(path, data.Bytes(), FilePermRW)
+  err = ioutil.WriteFile(path, data.Bytes(), filePermRW)
   if err != nil {
     log.Fatal("write error:", err)
   }
+  if m.wal != nil {
+    if err := m.wal.Truncate(); err != nil {
+      log.Fatal("wal truncate error:", err)
+    }
+  }
 }
 
+// Load reconstructs chunkHandle, chunks and handles from the gob snapshot
+// at path plus every operation recorded since in path+walSuffix, then
+// opens that log for future appends. A missing snapshot is not an error:
+// it means this is the manager's first time starting up.
 func (m *ChunkManager) Load(path string) {
   m.lock.Lock()
   defer m.lock.Unlock()
-  var data persistentData
+  m.loadSnapshot(path)
+  walPath := path + walSuffix
+  if err := ReplayWal(walPath, m.chunks, m.handles, &m.chunkHandle); err != nil {
+    log.Fatal("wal replay error:", err)
+  }
+  wal, err := OpenWal(walPath)
+  if err != nil {
+    log.Fatal("wal open error:", err)
+  }
+  m.wal = wal
+}
+
+// Pre-condition: call m.lock.Lock()
+func (m *ChunkManager) loadSnapshot(path string) {
   b, err := ioutil.ReadFile(path)
+  if os.IsNotExist(err) {
+    return
+  }
   if err != nil {
     log.Fatal("read error:", err)
   }
+  var data persistentData
   buffer := bytes.NewBuffer(b)
   dec := gob.NewDecoder(buffer)
   err = dec.Decode(&data)
@@ -208,7 +501,19 @@ func (m *ChunkManager) addChunk(path string, chunkIndex uint64) (*ChunkInfo, err
   m.chunks[path][chunkIndex] = &Chunk{
     ChunkHandle: handle,
   }
-  locations := random(m.chunkServers, 3)
+  locations, err := m.pickReplicas(3, nil)
+  if err != nil {
+    delete(m.chunks[path], chunkIndex)
+    m.chunkHandle--
+    return info, err
+  }
+  if m.wal != nil {
+    if err := m.wal.AppendAddChunk(path, chunkIndex, handle, locations); err != nil {
+      delete(m.chunks[path], chunkIndex)
+      m.chunkHandle--
+      return info, err
+    }
+  }
   m.locations[handle] = &ChunkInfo{
     Handle: handle,
     Locations: locations,
@@ -220,14 +525,145 @@ func (m *ChunkManager) addChunk(path string, chunkIndex uint64) (*ChunkInfo, err
   return m.locations[handle], nil
 }
 
-// Pick num elements randomly from array.
-func random(array []string, num int) []string {
-  ret := make([]string, num)
-  perm := rand.Perm(len(array))
+// candidate is a placement choice: a live chunk server along with the
+// usage weight and failure-domain labels pickReplicas needs to reason
+// about it.
+type candidate struct {
+  addr string
+  weight float64
+  rack string
+  zone string
+}
+
+// Pre-condition: call m.lock.Lock() or m.lock.RLock()
+// pickReplicas chooses num distinct, live chunk servers, weighted towards
+// servers with the most free capacity, excluding any address in exclude so
+// replicas of the same chunk don't end up co-located. It additionally
+// spreads picks across at least two distinct racks, and distinct zones
+// where the cluster reports them, falling back to a purely usage-weighted
+// choice once the live candidates run out of diversity to offer. exclude's
+// own racks/zones (looked up from m.servers, where known) seed that
+// diversity tracking, so a single re-replication pick via PickDestination
+// still avoids the racks/zones of a chunk's surviving replicas instead of
+// starting diversity tracking from scratch. A server is considered live
+// only if it heartbeated within chunkServerTimeout.
+func (m *ChunkManager) pickReplicas(num int, exclude []string) ([]string, error) {
+  excluded := make(map[string]bool, len(exclude))
+  usedRacks := make(map[string]bool)
+  usedZones := make(map[string]bool)
+  for _, addr := range exclude {
+    excluded[addr] = true
+    if info, ok := m.servers[addr]; ok {
+      if info.Rack != "" {
+        usedRacks[info.Rack] = true
+      }
+      if info.Zone != "" {
+        usedZones[info.Zone] = true
+      }
+    }
+  }
+  now := time.Now()
+  candidates := make([]candidate, 0, len(m.servers))
+  for addr, info := range m.servers {
+    if excluded[addr] {
+      continue
+    }
+    if now.Sub(info.LastHeartbeat) > chunkServerTimeout {
+      continue
+    }
+    candidates = append(candidates, candidate{addr, usageWeight(info), info.Rack, info.Zone})
+  }
+  if len(candidates) < num {
+    return nil, errors.New("not enough live chunk servers to place chunk")
+  }
+  picked := make([]string, 0, num)
   for i := 0; i < num; i++ {
-    ret[i] = array[perm[i]]
+    chosen := weightedPick(preferDiversity(candidates, usedRacks, usedZones))
+    picked = append(picked, chosen.addr)
+    if chosen.rack != "" {
+      usedRacks[chosen.rack] = true
+    }
+    if chosen.zone != "" {
+      usedZones[chosen.zone] = true
+    }
+    candidates = removeCandidate(candidates, chosen.addr)
+  }
+  return picked, nil
+}
+
+// preferDiversity narrows candidates to those that would add a new rack
+// (and, within that, a new zone) relative to what's already been picked.
+// If narrowing at either level would leave nothing to choose from -
+// because the cluster is too small to offer that diversity, or because it
+// doesn't report racks/zones at all - it falls back to the wider pool
+// rather than fail placement.
+func preferDiversity(candidates []candidate, usedRacks, usedZones map[string]bool) []candidate {
+  pool := candidates
+  if len(usedRacks) > 0 {
+    if narrowed := filterUnused(pool, usedRacks, func(c candidate) string { return c.rack }); len(narrowed) > 0 {
+      pool = narrowed
+    }
+  }
+  if len(usedZones) > 0 {
+    if narrowed := filterUnused(pool, usedZones, func(c candidate) string { return c.zone }); len(narrowed) > 0 {
+      pool = narrowed
+    }
+  }
+  return pool
+}
+
+// filterUnused keeps candidates whose key(c) is either unreported or not
+// yet in used.
+func filterUnused(candidates []candidate, used map[string]bool, key func(candidate) string) []candidate {
+  result := make([]candidate, 0, len(candidates))
+  for _, c := range candidates {
+    k := key(c)
+    if k == "" || !used[k] {
+      result = append(result, c)
+    }
   }
-  return ret
+  return result
+}
+
+// weightedPick picks one candidate at random, weighted by c.weight.
+func weightedPick(candidates []candidate) candidate {
+  total := 0.0
+  for _, c := range candidates {
+    total += c.weight
+  }
+  target := rand.Float64() * total
+  for _, c := range candidates {
+    target -= c.weight
+    if target <= 0 {
+      return c
+    }
+  }
+  return candidates[len(candidates)-1]
+}
+
+// removeCandidate drops the candidate at addr from candidates.
+func removeCandidate(candidates []candidate, addr string) []candidate {
+  for i, c := range candidates {
+    if c.addr == addr {
+      return append(candidates[:i], candidates[i+1:]...)
+    }
+  }
+  return candidates
+}
+
+// usageWeight turns a server's reported usage into a placement weight:
+// the emptier the server, the more likely it is to be picked. Servers that
+// haven't reported a capacity yet get a neutral weight so they can still be
+// chosen before their first usage figures arrive.
+func usageWeight(info *serverInfo) float64 {
+  if info.Capacity <= 0 {
+    return 1
+  }
+  weight := 1 - float64(info.Used)/float64(info.Capacity)
+  if weight < 0.01 {
+    weight = 0.01
+  }
+  return weight
 }
 
 // Add an element into an array. Need to ensure there are
@@ -239,4 +675,20 @@ func insert(array []string, elem string) []string {
     }
   }
   return append(array, elem)
+}
+
+// remove drops elem from array, if present. It never mutates array's
+// backing store in place: callers (FindLocations, AddChunk) hand out
+// info.Locations to RPC replies after releasing m.lock, so shifting the
+// tail in place would race with whoever is still reading that slice.
+func remove(array []string, elem string) []string {
+  for i, s := range array {
+    if s == elem {
+      out := make([]string, 0, len(array)-1)
+      out = append(out, array[:i]...)
+      out = append(out, array[i+1:]...)
+      return out
+    }
+  }
+  return array
 }
\ No newline at end of file
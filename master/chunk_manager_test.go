@@ -0,0 +1,104 @@
+package master
+
+import (
+  "testing"
+)
+
+// register brings up a chunk server with the given topology and a live
+// heartbeat, so it's a placement candidate.
+func register(m *ChunkManager, addr, rack, zone string, capacity, used int64) {
+  m.RegisterServer(addr, rack, zone, capacity)
+  m.UpdateServerInfo(addr, capacity, used, nil)
+}
+
+func TestAddChunkSpreadsReplicasAcrossRacks(t *testing.T) {
+  m := NewChunkManager(nil)
+  register(m, "s1", "rack-a", "", 100, 0)
+  register(m, "s2", "rack-a", "", 100, 0)
+  register(m, "s3", "rack-b", "", 100, 0)
+  register(m, "s4", "rack-b", "", 100, 0)
+  register(m, "s5", "rack-c", "", 100, 0)
+  register(m, "s6", "rack-c", "", 100, 0)
+
+  info, err := m.AddChunk("/foo", 0)
+  if err != nil {
+    t.Fatalf("AddChunk: %v", err)
+  }
+  if len(info.Locations) != 3 {
+    t.Fatalf("got %d locations, want 3: %v", len(info.Locations), info.Locations)
+  }
+  racks := make(map[string]bool)
+  for _, addr := range info.Locations {
+    racks[rackOf(t, m, addr)] = true
+  }
+  if len(racks) < 2 {
+    t.Errorf("replicas %v span only %d distinct racks, want at least 2", info.Locations, len(racks))
+  }
+}
+
+func TestPickReplicasFallsBackWhenClusterTooSmallForDiversity(t *testing.T) {
+  m := NewChunkManager(nil)
+  register(m, "s1", "rack-a", "", 100, 0)
+  register(m, "s2", "rack-a", "", 100, 0)
+  register(m, "s3", "rack-a", "", 100, 0)
+
+  m.lock.RLock()
+  picked, err := m.pickReplicas(3, nil)
+  m.lock.RUnlock()
+  if err != nil {
+    t.Fatalf("pickReplicas: %v", err)
+  }
+  if len(picked) != 3 {
+    t.Fatalf("got %d picks, want 3: %v", len(picked), picked)
+  }
+}
+
+func TestPickReplicasExcludesDeadAndListedServers(t *testing.T) {
+  m := NewChunkManager(nil)
+  register(m, "s1", "rack-a", "", 100, 0)
+  register(m, "s2", "rack-b", "", 100, 0)
+  // s3 is known but has never heartbeated, so it's not live.
+  m.RegisterServer("s3", "rack-c", "", 100)
+
+  m.lock.RLock()
+  picked, err := m.pickReplicas(1, []string{"s1"})
+  m.lock.RUnlock()
+  if err != nil {
+    t.Fatalf("pickReplicas: %v", err)
+  }
+  if len(picked) != 1 || picked[0] != "s2" {
+    t.Fatalf("picked = %v, want [s2]", picked)
+  }
+}
+
+func TestPickDestinationAvoidsRackOfSurvivingReplicas(t *testing.T) {
+  m := NewChunkManager(nil)
+  register(m, "survivor", "rack-a", "", 100, 0)
+  // same-rack-idle isn't in the exclude list (it doesn't hold the chunk),
+  // but it sits in the surviving replica's rack, so a rack-aware pick must
+  // still skip over it in favor of other-rack.
+  register(m, "same-rack-idle", "rack-a", "", 100, 0)
+  register(m, "other-rack", "rack-b", "", 100, 0)
+
+  for i := 0; i < 20; i++ {
+    dest, err := m.PickDestination([]string{"survivor"})
+    if err != nil {
+      t.Fatalf("PickDestination: %v", err)
+    }
+    if dest != "other-rack" {
+      t.Fatalf("PickDestination = %q, want %q (picked a server in the surviving replica's rack)",
+                dest, "other-rack")
+    }
+  }
+}
+
+func rackOf(t *testing.T, m *ChunkManager, addr string) string {
+  t.Helper()
+  for _, s := range m.ListServers() {
+    if s.Addr == addr {
+      return s.Rack
+    }
+  }
+  t.Fatalf("server %q not found", addr)
+  return ""
+}
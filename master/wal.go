@@ -0,0 +1,164 @@
+package master
+
+import (
+  "bufio"
+  "fmt"
+  "log"
+  "os"
+  "strconv"
+  "strings"
+)
+
+// walSuffix names the operation log that sits next to a ChunkManager's gob
+// snapshot file.
+const walSuffix = ".wal"
+
+// walOpVersion is recorded on every log record written by this binary.
+//
+// maxKnownOpVersion is the highest op-version this binary knows how to
+// replay. ReplayWal gates on it: a record whose op-version is newer is
+// skipped rather than failing replay, so a master binary can still start
+// up against a log a newer binary appended to (at the cost of not seeing
+// those records' effect until it's upgraded). A record at or below
+// maxKnownOpVersion with an op this binary doesn't recognize is instead a
+// genuine inconsistency and fails replay.
+const (
+  walOpVersion = 1
+  maxKnownOpVersion = 1
+)
+
+// Wal is an append-only, human-readable log of ChunkManager mutations,
+// modeled on NNCP's recfile format: one record per mutation, written as
+// "field: value" lines terminated by a blank line, e.g.
+//
+//   op: addChunk
+//   path: /foo
+//   index: 3
+//   handle: 42
+//   replicas: a,b,c
+//   op-version: 1
+//
+// It complements the gob snapshot taken by ChunkManager.Store: the
+// snapshot is a compaction checkpoint, and the log holds every mutation
+// since the last one. Records are fsynced before the caller's in-memory
+// mutation is acknowledged, so a crash loses at most the record being
+// written, not the whole log.
+type Wal struct {
+  f *os.File
+}
+
+// OpenWal opens, creating if necessary, the operation log at path for
+// appending.
+func OpenWal(path string) (*Wal, error) {
+  f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, filePermRW)
+  if err != nil {
+    return nil, err
+  }
+  return &Wal{f: f}, nil
+}
+
+// AppendAddChunk durably records the allocation of handle at (path, index)
+// with the given replicas.
+func (w *Wal) AppendAddChunk(path string, index, handle uint64, replicas []string) error {
+  var record strings.Builder
+  fmt.Fprintf(&record, "op: addChunk\n")
+  fmt.Fprintf(&record, "path: %s\n", path)
+  fmt.Fprintf(&record, "index: %d\n", index)
+  fmt.Fprintf(&record, "handle: %d\n", handle)
+  fmt.Fprintf(&record, "replicas: %s\n", strings.Join(replicas, ","))
+  fmt.Fprintf(&record, "op-version: %d\n", walOpVersion)
+  record.WriteString("\n")
+  if _, err := w.f.WriteString(record.String()); err != nil {
+    return err
+  }
+  return w.f.Sync()
+}
+
+// Truncate discards every record in the log, called once their effect has
+// been captured in a fresh gob snapshot.
+func (w *Wal) Truncate() error {
+  if err := w.f.Truncate(0); err != nil {
+    return err
+  }
+  _, err := w.f.Seek(0, os.SEEK_SET)
+  return err
+}
+
+// Close closes the underlying log file.
+func (w *Wal) Close() error {
+  return w.f.Close()
+}
+
+// ReplayWal reads every record in the operation log at path and applies it
+// to chunks, handles and chunkHandle, reconstructing the mutations made
+// since the gob snapshot those maps were loaded from. A missing log is not
+// an error: it just means nothing happened since the last snapshot.
+func ReplayWal(path string, chunks map[string](map[uint64]*Chunk),
+               handles map[uint64]*PathIndex, chunkHandle *uint64) error {
+  f, err := os.Open(path)
+  if os.IsNotExist(err) {
+    return nil
+  }
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+
+  fields := make(map[string]string)
+  scanner := bufio.NewScanner(f)
+  for scanner.Scan() {
+    line := scanner.Text()
+    if line == "" {
+      if err := applyWalRecord(fields, chunks, handles, chunkHandle); err != nil {
+        return err
+      }
+      fields = make(map[string]string)
+      continue
+    }
+    parts := strings.SplitN(line, ": ", 2)
+    if len(parts) != 2 {
+      continue
+    }
+    fields[parts[0]] = parts[1]
+  }
+  return scanner.Err()
+}
+
+// applyWalRecord replays a single decoded record against the in-memory
+// maps being restored. Records newer than maxKnownOpVersion are skipped
+// rather than failing the whole replay.
+func applyWalRecord(fields map[string]string, chunks map[string](map[uint64]*Chunk),
+                     handles map[uint64]*PathIndex, chunkHandle *uint64) error {
+  version, err := strconv.Atoi(fields["op-version"])
+  if err != nil {
+    return fmt.Errorf("wal: bad or missing op-version: %v", err)
+  }
+  if version > maxKnownOpVersion {
+    log.Printf("wal: skipping op %q at op-version %d, newer than this binary understands (%d)",
+               fields["op"], version, maxKnownOpVersion)
+    return nil
+  }
+  switch fields["op"] {
+  case "addChunk":
+    path := fields["path"]
+    index, err := strconv.ParseUint(fields["index"], 10, 64)
+    if err != nil {
+      return fmt.Errorf("wal: bad index in addChunk record: %v", err)
+    }
+    handle, err := strconv.ParseUint(fields["handle"], 10, 64)
+    if err != nil {
+      return fmt.Errorf("wal: bad handle in addChunk record: %v", err)
+    }
+    if _, ok := chunks[path]; !ok {
+      chunks[path] = make(map[uint64]*Chunk)
+    }
+    chunks[path][index] = &Chunk{ChunkHandle: handle}
+    handles[handle] = &PathIndex{Path: path, Index: index}
+    if handle >= *chunkHandle {
+      *chunkHandle = handle + 1
+    }
+    return nil
+  default:
+    return fmt.Errorf("wal: unknown op %q at op-version %d", fields["op"], version)
+  }
+}
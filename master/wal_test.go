@@ -0,0 +1,83 @@
+package master
+
+import (
+  "path/filepath"
+  "reflect"
+  "testing"
+)
+
+func TestWalAppendAndReplayRoundTrip(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "manager.wal")
+  w, err := OpenWal(path)
+  if err != nil {
+    t.Fatalf("OpenWal: %v", err)
+  }
+  if err := w.AppendAddChunk("/foo", 0, 1, []string{"a", "b", "c"}); err != nil {
+    t.Fatalf("AppendAddChunk: %v", err)
+  }
+  if err := w.AppendAddChunk("/foo", 1, 2, []string{"b", "c"}); err != nil {
+    t.Fatalf("AppendAddChunk: %v", err)
+  }
+  if err := w.AppendAddChunk("/bar", 0, 3, []string{"a"}); err != nil {
+    t.Fatalf("AppendAddChunk: %v", err)
+  }
+  if err := w.Close(); err != nil {
+    t.Fatalf("Close: %v", err)
+  }
+
+  chunks := make(map[string](map[uint64]*Chunk))
+  handles := make(map[uint64]*PathIndex)
+  var chunkHandle uint64
+  if err := ReplayWal(path, chunks, handles, &chunkHandle); err != nil {
+    t.Fatalf("ReplayWal: %v", err)
+  }
+
+  wantChunks := map[string](map[uint64]*Chunk){
+    "/foo": {0: {ChunkHandle: 1}, 1: {ChunkHandle: 2}},
+    "/bar": {0: {ChunkHandle: 3}},
+  }
+  if !reflect.DeepEqual(chunks, wantChunks) {
+    t.Errorf("chunks = %+v, want %+v", chunks, wantChunks)
+  }
+  wantHandles := map[uint64]*PathIndex{
+    1: {Path: "/foo", Index: 0},
+    2: {Path: "/foo", Index: 1},
+    3: {Path: "/bar", Index: 0},
+  }
+  if !reflect.DeepEqual(handles, wantHandles) {
+    t.Errorf("handles = %+v, want %+v", handles, wantHandles)
+  }
+  if chunkHandle != 4 {
+    t.Errorf("chunkHandle = %d, want 4", chunkHandle)
+  }
+}
+
+func TestReplayWalMissingFileIsNotAnError(t *testing.T) {
+  chunks := make(map[string](map[uint64]*Chunk))
+  handles := make(map[uint64]*PathIndex)
+  var chunkHandle uint64
+  path := filepath.Join(t.TempDir(), "does-not-exist.wal")
+  if err := ReplayWal(path, chunks, handles, &chunkHandle); err != nil {
+    t.Fatalf("ReplayWal on missing file: %v", err)
+  }
+  if len(chunks) != 0 || len(handles) != 0 || chunkHandle != 0 {
+    t.Errorf("expected no mutations from a missing log, got chunks=%v handles=%v chunkHandle=%d",
+              chunks, handles, chunkHandle)
+  }
+}
+
+func TestApplyWalRecordSkipsNewerOpVersion(t *testing.T) {
+  chunks := make(map[string](map[uint64]*Chunk))
+  handles := make(map[uint64]*PathIndex)
+  var chunkHandle uint64
+  fields := map[string]string{
+    "op": "someFutureOp",
+    "op-version": "99",
+  }
+  if err := applyWalRecord(fields, chunks, handles, &chunkHandle); err != nil {
+    t.Fatalf("applyWalRecord on a future op-version should be skipped, not failed: %v", err)
+  }
+  if len(chunks) != 0 || len(handles) != 0 {
+    t.Errorf("expected no mutation from a skipped record, got chunks=%v handles=%v", chunks, handles)
+  }
+}
@@ -0,0 +1,158 @@
+package simplegfs
+
+import "time"
+
+// NewClientIdReply is the reply to a NewClientId RPC.
+type NewClientIdReply struct {
+  ClientId uint64
+}
+
+// ListReply is the reply to a List RPC.
+type ListReply struct {
+  Paths []string
+}
+
+// FindLocationsArgs is the argument to a FindLocations RPC.
+type FindLocationsArgs struct {
+  Path string
+  ChunkIndex uint64
+}
+
+// FindLocationsReply is the reply to a FindLocations RPC.
+type FindLocationsReply struct {
+  ChunkHandle uint64
+  ChunkLocations []string
+}
+
+// FindLeaseHolderArgs is the argument to a FindLeaseHolder RPC.
+type FindLeaseHolderArgs struct {
+  ChunkHandle uint64
+}
+
+// FindLeaseHolderReply is the reply to a FindLeaseHolder RPC.
+type FindLeaseHolderReply struct {
+  Primary string
+  LeaseEnds time.Time
+}
+
+// AddChunkArgs is the argument to an AddChunk RPC.
+type AddChunkArgs struct {
+  Path string
+  ChunkIndex uint64
+}
+
+// AddChunkReply is the reply to an AddChunk RPC.
+type AddChunkReply struct {
+  ChunkHandle uint64
+  ChunkLocations []string
+}
+
+// ReportChunkArgs is the argument to a ReportChunk RPC. Chunk servers call
+// ReportChunk both to report the bytes they hold for a chunk and, when
+// Deleted is set, to tell the master they no longer have it.
+type ReportChunkArgs struct {
+  ServerAddress string
+  ChunkHandle uint64
+  Length int64
+  Deleted bool
+}
+
+// ReportChunkReply is the reply to a ReportChunk RPC.
+type ReportChunkReply struct {
+}
+
+// HeartbeatArgs is the argument to a Heartbeat RPC. In addition to lease
+// extension requests, a chunk server reports its storage usage and the
+// chunks it currently holds so the master can place future replicas and
+// reconcile its view of the cluster.
+type HeartbeatArgs struct {
+  Addr string
+  PendingExtensions []uint64
+
+  // Capacity and Used are both in bytes.
+  Capacity int64
+  Used int64
+
+  // ChunkHandles lists every chunk handle the server currently stores.
+  ChunkHandles []uint64
+}
+
+// HeartbeatReply is the reply to a Heartbeat RPC.
+type HeartbeatReply struct {
+  Reply string
+}
+
+// ReplicateChunkArgs instructs a chunk server to stream a chunk it holds to
+// another chunk server. The master issues this to restore replication
+// after a chunk server dies.
+type ReplicateChunkArgs struct {
+  ChunkHandle uint64
+  Destination string
+}
+
+// ReplicateChunkReply is the reply to a ReplicateChunk RPC.
+type ReplicateChunkReply struct {
+}
+
+// StatsReply is the reply to a Stats RPC, exposing counters useful for
+// monitoring the master's background re-replication subsystem.
+type StatsReply struct {
+  ReReplicationsStarted uint64
+  ReReplicationsInFlight int
+  DeadChunkServers int
+}
+
+// ReadChunkArgs is the argument to a chunk server's Read RPC.
+type ReadChunkArgs struct {
+  ChunkHandle uint64
+  Offset int64
+  Length int
+}
+
+// ReadChunkReply is the reply to a chunk server's Read RPC.
+type ReadChunkReply struct {
+  Data []byte
+}
+
+// WriteChunkArgs is the argument to a chunk server's Write RPC.
+type WriteChunkArgs struct {
+  ChunkHandle uint64
+  Offset int64
+  Data []byte
+}
+
+// WriteChunkReply is the reply to a chunk server's Write RPC.
+type WriteChunkReply struct {
+  Length int
+}
+
+// RegisterChunkserverArgs is the argument to a RegisterChunkserver RPC,
+// sent once by a chunk server on startup to declare the placement
+// topology it lives in. Zone may be left empty for deployments that don't
+// have that failure domain.
+type RegisterChunkserverArgs struct {
+  Addr string
+  Rack string
+  Zone string
+  Capacity int64
+}
+
+// RegisterChunkserverReply is the reply to a RegisterChunkserver RPC.
+type RegisterChunkserverReply struct {
+}
+
+// ChunkserverInfo is one chunk server's topology and usage, as known to
+// the master.
+type ChunkserverInfo struct {
+  Addr string
+  Rack string
+  Zone string
+  Capacity int64
+  Used int64
+  Alive bool
+}
+
+// ListChunkserversReply is the reply to a ListChunkservers RPC.
+type ListChunkserversReply struct {
+  Servers []ChunkserverInfo
+}